@@ -0,0 +1,58 @@
+// Command gateway-stress runs a reproducible gateway chaos scenario outside
+// of go test, for manual or CI-triggered runs.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules/gateway/stress"
+)
+
+func main() {
+	nodes := flag.Int("nodes", 50, "number of in-process gateways to create")
+	edges := flag.Int("edges", 4, "edges per node for random-regular/small-world topologies")
+	topologyFlag := flag.String("topology", "ring", "ring, random-regular, small-world, or full-mesh")
+	churn := flag.Duration("churn", 50*time.Millisecond, "average time between churn events")
+	duration := flag.Duration("duration", time.Minute, "total duration of the churn phase")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "random seed, for reproducing a failing run")
+	flag.Parse()
+
+	t, err := parseTopology(*topologyFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	cfg := stress.StressConfig{
+		Nodes:        *nodes,
+		EdgesPerNode: *edges,
+		Topology:     t,
+		ChurnRate:    *churn,
+		Duration:     *duration,
+		Seed:         *seed,
+	}
+	fmt.Printf("running gateway stress: %+v\n", cfg)
+	if err := stress.Run(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, "stress run failed:", err)
+		os.Exit(1)
+	}
+	fmt.Println("stress run passed")
+}
+
+func parseTopology(s string) (stress.Topology, error) {
+	switch s {
+	case "ring":
+		return stress.TopologyRing, nil
+	case "random-regular":
+		return stress.TopologyRandomRegular, nil
+	case "small-world":
+		return stress.TopologySmallWorld, nil
+	case "full-mesh":
+		return stress.TopologyFullMesh, nil
+	default:
+		return 0, fmt.Errorf("unknown topology %q", s)
+	}
+}
@@ -0,0 +1,303 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+	siasync "github.com/NebulousLabs/Sia/sync"
+)
+
+// nodesFile is the name of the file, inside a Gateway's persist directory,
+// that its peer list is saved to and loaded from.
+const nodesFile = "nodes.json"
+
+type (
+	// peer tracks a single connection accepted or established by the
+	// Gateway.
+	peer struct {
+		addr modules.NetAddress
+		conn net.Conn
+
+		// rpcMu serializes managedRPC calls against conn. Without it, a
+		// managedRPC goroutine abandoned by a cancelled RPCContext (it keeps
+		// running fn and, eventually, resetting the deadline it set) can
+		// interleave with a later managedRPC call on the same peer and
+		// clobber that call's deadline on the shared conn. Holding rpcMu for
+		// the whole set-fn-reset sequence forces such calls to serialize
+		// instead of racing.
+		rpcMu sync.Mutex
+	}
+
+	// persistedPeer is what's written to nodesFile.
+	persistedPeer struct {
+		NetAddress modules.NetAddress
+	}
+
+	// Gateway manages a set of peer connections and keeps them persisted to
+	// disk across restarts.
+	Gateway struct {
+		listener net.Listener
+		myAddr   modules.NetAddress
+
+		persistDir string
+
+		peers map[modules.NetAddress]*peer
+
+		mu sync.RWMutex
+		tg siasync.ThreadGroup
+	}
+)
+
+// New creates a Gateway listening on addr and persisting its peer list to
+// persistDir.
+func New(addr string, noBootstrap bool, persistDir string) (*Gateway, error) {
+	if persistDir == "" {
+		return nil, errors.New("gateway requires a non-empty persist directory")
+	}
+	if err := os.MkdirAll(persistDir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create persist directory: %w", err)
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not create listener: %w", err)
+	}
+
+	g := &Gateway{
+		listener:   l,
+		myAddr:     modules.NetAddress(l.Addr().String()),
+		persistDir: persistDir,
+		peers:      make(map[modules.NetAddress]*peer),
+	}
+
+	if err := g.load(); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("could not load persisted peer list: %w", err)
+	}
+
+	go g.threadedListen()
+
+	return g, nil
+}
+
+// Address returns the address the Gateway is listening on.
+func (g *Gateway) Address() modules.NetAddress {
+	return g.myAddr
+}
+
+// Peers returns the Gateway's current peer list.
+func (g *Gateway) Peers() []modules.Peer {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	peers := make([]modules.Peer, 0, len(g.peers))
+	for addr := range g.peers {
+		peers = append(peers, modules.Peer{NetAddress: addr})
+	}
+	return peers
+}
+
+// Connect establishes a connection to addr and adds it to the Gateway's
+// peer list. It's a thin wrapper around ConnectContext using
+// context.Background(), so a hung dial or handshake can only be escaped by
+// closing the whole Gateway; callers that want to bound or cancel a single
+// call should use ConnectContext directly.
+func (g *Gateway) Connect(addr modules.NetAddress) error {
+	return g.ConnectContext(context.Background(), addr)
+}
+
+// Disconnect terminates the connection to addr and removes it from the
+// Gateway's peer list.
+func (g *Gateway) Disconnect(addr modules.NetAddress) error {
+	if err := g.tg.Add(); err != nil {
+		return err
+	}
+	defer g.tg.Done()
+
+	g.mu.Lock()
+	p, ok := g.peers[addr]
+	if ok {
+		delete(g.peers, addr)
+	}
+	g.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("not connected to %v", addr)
+	}
+
+	p.conn.Close()
+	return g.save()
+}
+
+// RPC calls fn against addr. It's a thin wrapper around RPCContext using
+// context.Background(); callers that want a bounded or cancellable call
+// should use RPCContext directly.
+func (g *Gateway) RPC(addr modules.NetAddress, name string, fn modules.RPCFunc) error {
+	return g.RPCContext(context.Background(), addr, name, fn)
+}
+
+// Close stops the Gateway, closing its listener and disconnecting all
+// peers. Calling Close a second time, or calling Connect/Disconnect/RPC
+// after Close, returns siasync.ErrStopped.
+func (g *Gateway) Close() error {
+	// Close the listener before tg.Stop waits on in-flight goroutines:
+	// threadedListen is blocked in Accept() outside of tg's tracking, so
+	// nothing else unblocks it. The peer conns are closed for the same
+	// reason, in case a managedRPC call is blocked on one of them.
+	listenErr := g.listener.Close()
+
+	g.mu.Lock()
+	peers := g.peers
+	g.peers = make(map[modules.NetAddress]*peer)
+	g.mu.Unlock()
+	for _, p := range peers {
+		p.conn.Close()
+	}
+
+	if err := g.tg.Stop(); err != nil {
+		return err
+	}
+	return listenErr
+}
+
+// threadedListen accepts inbound connections until g.listener is closed by
+// Close, handing each one off to threadedAcceptConn so a slow or stalled
+// handshake on one connection can't hold up accepting the next.
+func (g *Gateway) threadedListen() {
+	for {
+		conn, err := g.listener.Accept()
+		if err != nil {
+			return
+		}
+		go g.threadedAcceptConn(conn)
+	}
+}
+
+// threadedAcceptConn completes the inbound half of the handshake ConnectContext
+// starts on the dialing side: it reads the dialer's listening address off the
+// wire and registers the dialer as a peer, so a Connect from one Gateway to
+// another makes each a peer of the other instead of only the caller.
+func (g *Gateway) threadedAcceptConn(conn net.Conn) {
+	if err := g.tg.Add(); err != nil {
+		conn.Close()
+		return
+	}
+	defer g.tg.Done()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return
+	}
+	addr := modules.NetAddress(strings.TrimSpace(line))
+
+	g.managedAcceptConnPeer(conn, addr, nil)
+}
+
+// managedAcceptConnPeer finishes accepting conn as a peer at addr and, on
+// success, adds it to the Gateway's peer list. If addr is already a peer,
+// conn is redundant (e.g. two near-simultaneous Connects, or a re-dial of an
+// address we never disconnected from): it's closed immediately rather than
+// replacing the existing connection, which would leak the old one's fd.
+//
+// cancelled, if non-nil, is checked under g.mu right before the peer would be
+// registered: if it's already closed, conn is closed and discarded instead of
+// being added, so a caller that gave up on the call (ConnectContext, when its
+// ctx is cancelled) can't have a peer registered out from under it after
+// telling the caller the call failed. A nil cancelled behaves as if it were
+// never closed, which is what threadedAcceptConn's inbound call wants.
+func (g *Gateway) managedAcceptConnPeer(conn net.Conn, addr modules.NetAddress, cancelled <-chan struct{}) error {
+	g.mu.Lock()
+	select {
+	case <-cancelled:
+		g.mu.Unlock()
+		conn.Close()
+		return nil
+	default:
+	}
+	if _, ok := g.peers[addr]; ok {
+		g.mu.Unlock()
+		conn.Close()
+		return nil
+	}
+	g.peers[addr] = &peer{addr: addr, conn: conn}
+	g.mu.Unlock()
+
+	return g.save()
+}
+
+// managedRPC looks up addr's connection and runs fn against it, honoring
+// ctx's deadline, if any, for the underlying conn. It holds p.rpcMu for the
+// whole set-fn-reset sequence, so a call abandoned by a cancelled RPCContext
+// can't reset the deadline out from under a later call to the same peer.
+func (g *Gateway) managedRPC(ctx context.Context, addr modules.NetAddress, name string, fn modules.RPCFunc) error {
+	g.mu.RLock()
+	p, ok := g.peers[addr]
+	g.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("not connected to %v", addr)
+	}
+
+	p.rpcMu.Lock()
+	defer p.rpcMu.Unlock()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		p.conn.SetDeadline(deadline)
+		defer p.conn.SetDeadline(time.Time{})
+	}
+	return fn(p.conn)
+}
+
+// load reads a previously persisted peer list from nodesFile in
+// g.persistDir, if one exists, and reconnects to each address in the
+// background so the peer set survives a restart. Reconnecting through
+// Connect (rather than inserting the addresses into g.peers directly) is
+// what actually keeps "peers" meaningful: a persisted address with no live
+// conn isn't a peer, and a peer that's gone in the meantime shouldn't block
+// startup on it.
+func (g *Gateway) load() error {
+	b, err := ioutil.ReadFile(filepath.Join(g.persistDir, nodesFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var peers []persistedPeer
+	if err := json.Unmarshal(b, &peers); err != nil {
+		return err
+	}
+
+	for _, p := range peers {
+		addr := p.NetAddress
+		go g.Connect(addr)
+	}
+	return nil
+}
+
+// save persists the Gateway's current peer list to nodesFile in
+// g.persistDir.
+func (g *Gateway) save() error {
+	g.mu.RLock()
+	peers := make([]persistedPeer, 0, len(g.peers))
+	for addr := range g.peers {
+		peers = append(peers, persistedPeer{NetAddress: addr})
+	}
+	g.mu.RUnlock()
+
+	b, err := json.Marshal(peers)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(g.persistDir, nodesFile), b, 0660)
+}
@@ -0,0 +1,165 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+const (
+	// connectDialTimeout bounds how long ConnectContext waits to dial a peer
+	// when ctx carries no deadline of its own. Connect uses the same bound,
+	// since it calls ConnectContext with context.Background().
+	connectDialTimeout = 5 * time.Second
+
+	// rpcDeadlineFraction is the fraction of a parent context's remaining
+	// time budget that a single call dispatched through RPCContext may
+	// consume, so that one slow peer can't exhaust a caller's entire
+	// deadline across a batch of RPCs.
+	rpcDeadlineFraction = 0.5
+)
+
+// withDeadlineOrFallback returns a child of ctx that honors ctx's own
+// deadline unchanged if it has one, or is bounded by fallback if it doesn't.
+// Use this for a single one-shot call, where the caller's whole budget
+// should be available to that call.
+func withDeadlineOrFallback(ctx context.Context, fallback time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, fallback)
+}
+
+// withFractionalDeadline returns a child of ctx bounded to frac of ctx's
+// remaining time budget, or to fallback if ctx carries no deadline. Use this
+// when ctx's budget is meant to be shared across multiple calls (e.g. a
+// batch of RPCs), so that one slow call can't exhaust the whole budget by
+// itself. The caller is responsible for calling the returned cancel func.
+func withFractionalDeadline(ctx context.Context, frac float64, fallback time.Duration) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithTimeout(ctx, fallback)
+	}
+	remaining := time.Until(deadline)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return context.WithTimeout(ctx, time.Duration(float64(remaining)*frac))
+}
+
+// ConnectContext is the context-aware implementation backing Connect: it
+// dials addr and adds it to the Gateway's peer list, honoring ctx for
+// cancellation and deadlines on both the dial itself and the handshake that
+// follows it. Connect is a thin wrapper that calls ConnectContext with
+// context.Background(). Since a single Connect call is meant to get the
+// caller's entire deadline rather than a fraction of it, this uses
+// withDeadlineOrFallback rather than withFractionalDeadline.
+func (g *Gateway) ConnectContext(ctx context.Context, addr modules.NetAddress) error {
+	if err := g.tg.Add(); err != nil {
+		return err
+	}
+	defer g.tg.Done()
+
+	// callCtx bounds the dial and the handshake that follows it, so a call
+	// made with context.Background() (as Connect does) still can't hang
+	// forever on a peer that accepts the TCP connection but stalls during
+	// the handshake.
+	callCtx, cancel := withDeadlineOrFallback(ctx, connectDialTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(callCtx, "tcp", string(addr))
+	if err != nil {
+		if callCtx.Err() != nil {
+			return callCtx.Err()
+		}
+		return fmt.Errorf("could not dial %v: %w", addr, err)
+	}
+
+	// Tell addr our own listening address, so its threadedAcceptConn can
+	// register us as a peer in turn; without this, Connect would only ever
+	// be one-directional. The write is bounded by callCtx's deadline, which
+	// withDeadlineOrFallback always sets.
+	if deadline, ok := callCtx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+	}
+	if _, err := fmt.Fprintf(conn, "%s\n", g.myAddr); err != nil {
+		conn.Close()
+		if callCtx.Err() != nil {
+			return callCtx.Err()
+		}
+		return fmt.Errorf("could not send handshake to %v: %w", addr, err)
+	}
+	conn.SetWriteDeadline(time.Time{})
+
+	// managedAcceptConnPeer itself does no I/O on conn (the handshake line
+	// was already read by the other side's threadedAcceptConn), so, unlike
+	// the dial and the handshake write above, it can't be interrupted
+	// through ctx or conn. Instead, give it its own tg accounting (tg.Add/Done below, not
+	// the one deferred at the top of this call) so Close waits for it
+	// rather than racing it, and a cancelled channel it checks under g.mu
+	// right before registering the peer, so the callCtx.Done() branch below
+	// can tell it to back off instead of just discarding its result.
+	if err := g.tg.Add(); err != nil {
+		conn.Close()
+		return err
+	}
+	done := make(chan error, 1)
+	cancelled := make(chan struct{})
+	go func() {
+		defer g.tg.Done()
+		done <- g.managedAcceptConnPeer(conn, addr, cancelled)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-callCtx.Done():
+		close(cancelled)
+		conn.Close()
+		// managedAcceptConnPeer may have registered conn just before
+		// cancelled was closed; wait for it and undo that registration, so
+		// the peer doesn't stay registered after the call reports failure.
+		<-done
+		g.mu.Lock()
+		p, registered := g.peers[addr]
+		registered = registered && p.conn == conn
+		if registered {
+			delete(g.peers, addr)
+		}
+		g.mu.Unlock()
+		if registered {
+			g.save()
+		}
+		return callCtx.Err()
+	}
+}
+
+// RPCContext is the context-aware counterpart to RPC: it derives a
+// fractional deadline from ctx so a single slow peer can't exhaust a
+// caller's whole budget, then runs fn against addr. RPC is a thin wrapper
+// that calls RPCContext with context.Background().
+func (g *Gateway) RPCContext(ctx context.Context, addr modules.NetAddress, name string, fn modules.RPCFunc) error {
+	if err := g.tg.Add(); err != nil {
+		return err
+	}
+	defer g.tg.Done()
+
+	callCtx, cancel := withFractionalDeadline(ctx, rpcDeadlineFraction, connectDialTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- g.managedRPC(callCtx, addr, name, fn)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-callCtx.Done():
+		return callCtx.Err()
+	}
+}
@@ -0,0 +1,177 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// TestConnectContextCancel mirrors the TestReadPostCancel/TestWritePostCancel
+// pattern: it blocks a goroutine in ConnectContext against an address that
+// will never answer, cancels the context, and checks that the call returns
+// promptly with a context error instead of hanging or returning
+// siasync.ErrStopped (which only applies once the gateway itself is closed).
+func TestConnectContextCancel(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	g := newTestingGateway("TestConnectContextCancel", t)
+	defer g.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		// 10.255.255.1 is unroutable from a test sandbox, so the dial hangs
+		// until it's cancelled.
+		errChan <- g.ConnectContext(ctx, modules.NetAddress("10.255.255.1:9981"))
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errChan:
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConnectContext did not return promptly after cancellation")
+	}
+}
+
+// TestRPCContextCancel mirrors TestConnectContextCancel for the RPC side: it
+// blocks a goroutine in RPCContext against a peer that never answers,
+// cancels the context, and checks the call returns promptly with a context
+// error instead of hanging.
+func TestRPCContextCancel(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	g1 := newTestingGateway("TestRPCContextCancel1", t)
+	defer g1.Close()
+	g2 := newTestingGateway("TestRPCContextCancel2", t)
+	defer g2.Close()
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal("failed to connect:", err)
+	}
+
+	blockUntilClosed := func(conn net.Conn) error {
+		_, err := conn.Read(make([]byte, 1))
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- g1.RPCContext(ctx, g2.Address(), "block", blockUntilClosed)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errChan:
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RPCContext did not return promptly after cancellation")
+	}
+}
+
+// TestManagedRPCSerializesAfterCancel checks that a managedRPC call left
+// running in the background by a cancelled RPCContext doesn't reset the
+// conn's deadline out from under a later call to the same peer: the later
+// call must still time out on its own deadline rather than blocking forever
+// because the abandoned call cleared it first.
+func TestManagedRPCSerializesAfterCancel(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	g1 := newTestingGateway("TestManagedRPCSerializesAfterCancel1", t)
+	defer g1.Close()
+	g2 := newTestingGateway("TestManagedRPCSerializesAfterCancel2", t)
+	defer g2.Close()
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal("failed to connect:", err)
+	}
+
+	blockUntilClosed := func(conn net.Conn) error {
+		_, err := conn.Read(make([]byte, 1))
+		return err
+	}
+
+	firstCtx, firstCancel := context.WithTimeout(context.Background(), 25*time.Millisecond)
+	defer firstCancel()
+	if err := g1.RPCContext(firstCtx, g2.Address(), "block", blockUntilClosed); err == nil {
+		t.Fatal("expected first RPCContext call to fail against an unresponsive peer")
+	}
+
+	// The second call goes straight to managedRPC: RPCContext would return
+	// on its own deadline even if managedRPC's read blocked forever.
+	secondCtx, secondCancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer secondCancel()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- g1.managedRPC(secondCtx, g2.Address(), "block", blockUntilClosed)
+	}()
+	select {
+	case err := <-errChan:
+		if err == nil {
+			t.Fatal("expected second managedRPC call to fail against an unresponsive peer")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("second managedRPC call outlived any deadline involved; its timeout may have been cleared by the abandoned first call")
+	}
+}
+
+// TestWithDeadlineOrFallback checks that a single-call context keeps the
+// caller's full deadline instead of having it cut down.
+func TestWithDeadlineOrFallback(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	child, childCancel := withDeadlineOrFallback(parent, connectDialTimeout)
+	defer childCancel()
+
+	parentDeadline, _ := parent.Deadline()
+	childDeadline, ok := child.Deadline()
+	if !ok {
+		t.Fatal("expected child to inherit a deadline")
+	}
+	if d := childDeadline.Sub(parentDeadline); d < -time.Millisecond || d > time.Millisecond {
+		t.Fatalf("expected child to keep the parent's full deadline, off by %v", d)
+	}
+}
+
+// TestWithFractionalDeadline checks that a context meant to be shared across
+// a batch of calls is cut down to roughly frac of its remaining budget,
+// rather than being passed through unchanged.
+func TestWithFractionalDeadline(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	child, childCancel := withFractionalDeadline(parent, rpcDeadlineFraction, connectDialTimeout)
+	defer childCancel()
+
+	remaining := time.Until(mustDeadline(t, child))
+	want := time.Duration(float64(time.Second) * rpcDeadlineFraction)
+	if d := remaining - want; d < -100*time.Millisecond || d > 100*time.Millisecond {
+		t.Fatalf("expected about %v remaining, got %v", want, remaining)
+	}
+}
+
+func mustDeadline(t *testing.T, ctx context.Context) time.Time {
+	t.Helper()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		t.Fatal("expected ctx to carry a deadline")
+	}
+	return deadline
+}
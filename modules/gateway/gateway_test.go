@@ -7,12 +7,32 @@ import (
 	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/NebulousLabs/Sia/build"
 	"github.com/NebulousLabs/Sia/modules"
 	siasync "github.com/NebulousLabs/Sia/sync"
 )
 
+// waitForPeer polls g.Peers() until addr shows up or timeout elapses,
+// returning whether it was found. It's needed because a Gateway only learns
+// about the dialing side of a Connect once its accept loop has processed the
+// handshake, which happens on its own goroutine.
+func waitForPeer(g *Gateway, addr modules.NetAddress, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		for _, p := range g.Peers() {
+			if p.NetAddress == addr {
+				return true
+			}
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
 // newTestingGateway returns a gateway read to use in a testing environment.
 func newTestingGateway(name string, t *testing.T) *Gateway {
 	if testing.Short() {
@@ -97,6 +117,14 @@ func TestPeers(t *testing.T) {
 	if len(peers) != 1 || peers[0].NetAddress != g2.Address() {
 		t.Fatal("g1 has bad peer list:", peers)
 	}
+
+	// g1 dialed g2, but g2's accept loop should register g1 as a peer in
+	// turn once it processes the handshake, making the connection
+	// bidirectional.
+	if !waitForPeer(g2, g1.Address(), time.Second) {
+		t.Fatal("g2 never learned about g1 as a peer")
+	}
+
 	err = g1.Disconnect(g2.Address())
 	if err != nil {
 		t.Fatal("failed to disconnect:", err)
@@ -147,6 +175,90 @@ func TestClose(t *testing.T) {
 	}
 }
 
+// TestLoadReconnectsPeers checks that a Gateway restarted against the same
+// persistDir reconnects to the peers it had saved to nodes.json, rather than
+// starting with an empty peer list.
+func TestLoadReconnectsPeers(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	g2 := newTestingGateway("TestLoadReconnectsPeers - 2", t)
+	defer g2.Close()
+
+	dir := build.TempDir("gateway", "TestLoadReconnectsPeers - 1")
+	g1, err := New("localhost:0", false, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g1.Connect(g2.Address()); err != nil {
+		t.Fatal("failed to connect:", err)
+	}
+	if err := g1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	g1, err = New("localhost:0", false, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g1.Close()
+
+	if !waitForPeer(g1, g2.Address(), time.Second) {
+		t.Fatal("restarted gateway never reconnected to its persisted peer")
+	}
+}
+
+// TestManagedAcceptConnPeerCancelled checks that managedAcceptConnPeer
+// no-ops instead of registering a peer when handed an already-closed
+// cancelled channel: this is what lets ConnectContext's spawned goroutine
+// back off instead of racing a peer into g.peers after the caller has
+// already been told the call failed.
+func TestManagedAcceptConnPeerCancelled(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	g := newTestingGateway("TestManagedAcceptConnPeerCancelled", t)
+	defer g.Close()
+
+	conn, remote := net.Pipe()
+	defer remote.Close()
+
+	cancelled := make(chan struct{})
+	close(cancelled)
+
+	if err := g.managedAcceptConnPeer(conn, "cancelled:1234", cancelled); err != nil {
+		t.Fatal(err)
+	}
+	if peers := g.Peers(); len(peers) != 0 {
+		t.Fatalf("expected no peers registered, got %v", peers)
+	}
+}
+
+// TestManagedAcceptConnPeerNotCancelled checks that managedAcceptConnPeer
+// still registers the peer normally when its cancelled channel is nil,
+// matching the behavior threadedAcceptConn's inbound call relies on.
+func TestManagedAcceptConnPeerNotCancelled(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	g := newTestingGateway("TestManagedAcceptConnPeerNotCancelled", t)
+	defer g.Close()
+
+	conn, remote := net.Pipe()
+	defer remote.Close()
+
+	addr := modules.NetAddress("not-cancelled:1234")
+	if err := g.managedAcceptConnPeer(conn, addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !waitForPeer(g, addr, time.Second) {
+		t.Fatal("expected peer to be registered")
+	}
+}
+
 // TestParallelClose spins up 3 gateways, connects them all, and then closes
 // them in parallel. The goal of this test is to make it more vulnerable to any
 // potential nondeterministic failures.
@@ -0,0 +1,366 @@
+// Package stress provides a chaos-testing harness for modules/gateway: spin
+// up many in-process nodes, wire them into a configurable topology, drive
+// continuous churn, and check that core gateway invariants hold throughout.
+// It mirrors the value that go-ethereum's miner/stress_clique.go and
+// miner/stress_ethash.go provide for a consensus engine, but for the
+// gateway's P2P networking layer instead.
+package stress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/modules/gateway"
+)
+
+// Topology describes how stress nodes are wired together before churn
+// begins.
+type Topology int
+
+const (
+	// TopologyRing connects every node to its two ring neighbors.
+	TopologyRing Topology = iota
+	// TopologyRandomRegular has every node dial EdgesPerNode randomly chosen
+	// peers. Picks may repeat and inbound dials add to a node's peers, so a
+	// node's degree is not exactly EdgesPerNode.
+	TopologyRandomRegular
+	// TopologySmallWorld starts from a ring and adds
+	// Nodes*EdgesPerNode/4 random shortcut edges on top of it; no ring edge
+	// is removed.
+	TopologySmallWorld
+	// TopologyFullMesh connects every node to every other node.
+	TopologyFullMesh
+)
+
+// StressConfig configures a stress run.
+type StressConfig struct {
+	Nodes        int // number of in-process gateways to create
+	EdgesPerNode int // used by TopologyRandomRegular and TopologySmallWorld
+	Topology     Topology
+	ChurnRate    time.Duration // average time between churn events
+	Duration     time.Duration // total duration of the churn phase
+	Seed         int64
+}
+
+// node is a stress-test gateway plus the bookkeeping needed to restart it
+// after a simulated crash.
+type node struct {
+	name string
+	dir  string
+	g    *gateway.Gateway
+}
+
+// newNode creates a fresh in-process gateway persisting to its own temp
+// directory, analogous to newTestingGateway in gateway_test.go.
+func newNode(name string) (*node, error) {
+	dir := build.TempDir("gateway-stress", name)
+	g, err := gateway.New("localhost:0", false, dir)
+	if err != nil {
+		return nil, fmt.Errorf("creating node %s: %w", name, err)
+	}
+	return &node{name: name, dir: dir, g: g}, nil
+}
+
+// restart closes n's gateway and replaces it with a new one backed by the
+// same persist directory, simulating a node crashing and coming back up.
+func (n *node) restart() error {
+	n.g.Close()
+	g, err := gateway.New("localhost:0", false, n.dir)
+	if err != nil {
+		return fmt.Errorf("restarting node %s: %w", n.name, err)
+	}
+	n.g = g
+	return nil
+}
+
+// checkNodesJSON verifies that n's persisted peer list is still valid JSON.
+// RunStress calls this after every churn event to catch corruption caused by
+// a simulated crash landing mid-write.
+func (n *node) checkNodesJSON() error {
+	b, err := ioutil.ReadFile(filepath.Join(n.dir, "nodes.json"))
+	if err != nil {
+		return nil // nothing persisted yet
+	}
+	var v interface{}
+	return json.Unmarshal(b, &v)
+}
+
+// randOtherIndex returns a random index into a slice of length n that's not
+// exclude, so callers picking a random peer don't wire a node to itself. n
+// must be at least 2.
+func randOtherIndex(rng *rand.Rand, n, exclude int) int {
+	for {
+		j := rng.Intn(n)
+		if j != exclude {
+			return j
+		}
+	}
+}
+
+// buildTopology connects nodes to each other according to cfg.Topology.
+func buildTopology(rng *rand.Rand, nodes []*node, cfg StressConfig) error {
+	connect := func(a, b *node) error {
+		if err := a.g.Connect(b.g.Address()); err != nil {
+			return fmt.Errorf("connecting %s to %s: %w", a.name, b.name, err)
+		}
+		return nil
+	}
+
+	switch cfg.Topology {
+	case TopologyRing:
+		for i := range nodes {
+			if err := connect(nodes[i], nodes[(i+1)%len(nodes)]); err != nil {
+				return err
+			}
+		}
+	case TopologyFullMesh:
+		for i := range nodes {
+			for j := i + 1; j < len(nodes); j++ {
+				if err := connect(nodes[i], nodes[j]); err != nil {
+					return err
+				}
+			}
+		}
+	case TopologyRandomRegular:
+		for i := range nodes {
+			for e := 0; e < cfg.EdgesPerNode; e++ {
+				if err := connect(nodes[i], nodes[randOtherIndex(rng, len(nodes), i)]); err != nil {
+					return err
+				}
+			}
+		}
+	case TopologySmallWorld:
+		for i := range nodes {
+			if err := connect(nodes[i], nodes[(i+1)%len(nodes)]); err != nil {
+				return err
+			}
+		}
+		rewires := len(nodes) * cfg.EdgesPerNode / 4
+		for i := 0; i < rewires; i++ {
+			a := rng.Intn(len(nodes))
+			b := randOtherIndex(rng, len(nodes), a)
+			if err := connect(nodes[a], nodes[b]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown topology %v", cfg.Topology)
+	}
+	return nil
+}
+
+// maxChurnFailureRate is the fraction of Connect/Disconnect calls during
+// churn that are tolerated as failures before churn gives up and fails the
+// run; catching a regression in Connect/Disconnect is the whole point of
+// this harness, so churn must not silently absorb their errors.
+const maxChurnFailureRate = 0.05
+
+// churn randomly connects, disconnects, and kills/restarts nodes for
+// cfg.Duration, validating nodes.json after every event and tracking the
+// rate of Connect/Disconnect failures.
+func churn(rng *rand.Rand, nodes []*node, cfg StressConfig) error {
+	var attempts, failures int
+
+	deadline := time.Now().Add(cfg.Duration)
+	for time.Now().Before(deadline) {
+		time.Sleep(jitter(rng, cfg.ChurnRate))
+
+		a := nodes[rng.Intn(len(nodes))]
+		b := nodes[rng.Intn(len(nodes))]
+		if a == b {
+			continue
+		}
+
+		var err error
+		switch rng.Intn(3) {
+		case 0:
+			err = a.g.Connect(b.g.Address())
+		case 1:
+			// Disconnecting from a node that isn't a peer fails by design,
+			// so such a pick is skipped rather than counted as a failure.
+			if !hasPeer(a, b.g.Address()) {
+				continue
+			}
+			err = a.g.Disconnect(b.g.Address())
+		case 2:
+			if rerr := a.restart(); rerr != nil {
+				return rerr
+			}
+			err = a.g.Connect(b.g.Address())
+		}
+		attempts++
+		if err != nil {
+			failures++
+		}
+
+		if err := a.checkNodesJSON(); err != nil {
+			return fmt.Errorf("corrupt nodes.json for %s: %w", a.name, err)
+		}
+	}
+
+	if attempts > 0 && float64(failures)/float64(attempts) > maxChurnFailureRate {
+		return fmt.Errorf("churn saw %d/%d Connect/Disconnect failures, exceeding the %.0f%% acceptable rate", failures, attempts, maxChurnFailureRate*100)
+	}
+	return nil
+}
+
+// jitter returns a random duration in [rate/2, rate*3/2), so churn events
+// don't all land in lockstep.
+func jitter(rng *rand.Rand, rate time.Duration) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	return rate/2 + time.Duration(rng.Int63n(int64(rate)))
+}
+
+// converged reports whether every node's peer count is unchanged across two
+// samples taken pollInterval apart.
+func converged(nodes []*node, pollInterval time.Duration) bool {
+	before := make([]int, len(nodes))
+	for i, n := range nodes {
+		before[i] = len(n.g.Peers())
+	}
+	time.Sleep(pollInterval)
+	for i, n := range nodes {
+		if len(n.g.Peers()) != before[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPeer reports whether n considers addr one of its peers.
+func hasPeer(n *node, addr modules.NetAddress) bool {
+	for _, p := range n.g.Peers() {
+		if p.NetAddress == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMutualPeering verifies that every edge among nodes is mutual: if a
+// considers b a peer, b must consider a a peer too, since accepting a
+// connection registers the dialer as a peer in turn. A topology or churn bug
+// that leaves a connection one-directional would otherwise slip past
+// converged, which only checks that peer counts are stable.
+func checkMutualPeering(nodes []*node) error {
+	byAddr := make(map[modules.NetAddress]*node, len(nodes))
+	for _, n := range nodes {
+		byAddr[n.g.Address()] = n
+	}
+
+	for _, a := range nodes {
+		for _, p := range a.g.Peers() {
+			b, ok := byAddr[p.NetAddress]
+			if !ok {
+				continue // peer outside this run
+			}
+			if !hasPeer(b, a.g.Address()) {
+				return fmt.Errorf("one-way edge: %s sees %s as a peer, but not vice versa", a.name, b.name)
+			}
+		}
+	}
+	return nil
+}
+
+// waitForMutualPeering retries checkMutualPeering until it passes or timeout
+// elapses, returning the last error seen; a freshly accepted connection
+// briefly looks one-directional until the accept side's goroutine catches up.
+func waitForMutualPeering(nodes []*node, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := checkMutualPeering(nodes)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// closeAll closes every node's gateway in parallel, ignoring errors from
+// nodes that are nil or already closed.
+func closeAll(nodes []*node) {
+	var wg sync.WaitGroup
+	for _, n := range nodes {
+		if n == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(n *node) {
+			defer wg.Done()
+			n.g.Close()
+		}(n)
+	}
+	wg.Wait()
+}
+
+// Run executes a stress scenario against cfg and returns an error describing
+// the first invariant violation encountered, or nil if the run completed
+// cleanly. Unlike RunStress, Run doesn't depend on testing.TB, so it can be
+// driven from the cmd/gateway-stress CLI as well as from go test.
+func Run(cfg StressConfig) error {
+	if (cfg.Topology == TopologyRandomRegular || cfg.Topology == TopologySmallWorld) && cfg.Nodes < 2 {
+		return fmt.Errorf("topology %v requires at least 2 nodes, got %d", cfg.Topology, cfg.Nodes)
+	}
+
+	rng := rand.New(rand.NewSource(cfg.Seed))
+
+	nodes := make([]*node, 0, cfg.Nodes)
+	for i := 0; i < cfg.Nodes; i++ {
+		n, err := newNode(fmt.Sprintf("node-%d", i))
+		if err != nil {
+			closeAll(nodes)
+			return err
+		}
+		nodes = append(nodes, n)
+	}
+
+	if err := buildTopology(rng, nodes, cfg); err != nil {
+		closeAll(nodes)
+		return err
+	}
+	if err := churn(rng, nodes, cfg); err != nil {
+		closeAll(nodes)
+		return err
+	}
+	if !converged(nodes, cfg.ChurnRate) {
+		closeAll(nodes)
+		return fmt.Errorf("peer sets did not converge after churn stopped")
+	}
+	if err := waitForMutualPeering(nodes, cfg.ChurnRate); err != nil {
+		closeAll(nodes)
+		return err
+	}
+
+	before := runtime.NumGoroutine()
+	closeAll(nodes)
+	for i := 0; i < 10; i++ {
+		if runtime.NumGoroutine() <= before {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("goroutine leak: %d goroutines running before close, %d still running after", before, runtime.NumGoroutine())
+}
+
+// RunStress is the testing.TB-facing entry point: it runs cfg through Run and
+// fails t with the returned error, analogous to the helpers in
+// modules/gateway's own tests.
+func RunStress(t testing.TB, cfg StressConfig) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	if err := Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
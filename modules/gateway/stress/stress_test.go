@@ -0,0 +1,58 @@
+package stress
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// TestRunRejectsSingleNodeRandomTopology checks that Run returns an error
+// instead of hanging when asked to build a random-regular or small-world
+// topology with fewer than 2 nodes: randOtherIndex can't pick a distinct
+// peer out of a single node, so rng.Intn(1) would loop forever.
+func TestRunRejectsSingleNodeRandomTopology(t *testing.T) {
+	for _, topology := range []Topology{TopologyRandomRegular, TopologySmallWorld} {
+		err := Run(StressConfig{
+			Nodes:        1,
+			EdgesPerNode: 2,
+			Topology:     topology,
+			ChurnRate:    time.Millisecond,
+			Duration:     time.Millisecond,
+			Seed:         1,
+		})
+		if err == nil {
+			t.Fatalf("expected an error for topology %v with 1 node, got nil", topology)
+		}
+	}
+}
+
+// TestRandOtherIndexNeverSelf checks that randOtherIndex never returns
+// exclude, so buildTopology's random branches can't wire a node to itself.
+func TestRandOtherIndexNeverSelf(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for exclude := 0; exclude < 5; exclude++ {
+		for i := 0; i < 1000; i++ {
+			if j := randOtherIndex(rng, 5, exclude); j == exclude {
+				t.Fatalf("randOtherIndex(_, 5, %d) returned %d", exclude, j)
+			}
+		}
+	}
+}
+
+// TestStressSmoke runs a small, fast scenario to make sure RunStress itself
+// works end to end; long chaos runs are meant to be driven through
+// cmd/gateway-stress instead of go test.
+func TestStressSmoke(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	RunStress(t, StressConfig{
+		Nodes:        4,
+		EdgesPerNode: 2,
+		Topology:     TopologyRing,
+		ChurnRate:    10 * time.Millisecond,
+		Duration:     200 * time.Millisecond,
+		Seed:         1,
+	})
+}
@@ -1,42 +1,149 @@
 package miner
 
 import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"math/rand"
 	"sync"
+	"time"
 
 	"github.com/NebulousLabs/Sia/consensus"
 )
 
-type Miner struct {
-	// Block variables - helps the miner construct the next block.
-	parent            consensus.BlockID
-	transactions      []consensus.Transaction
-	address           consensus.CoinAddress
-	target            consensus.Target
-	earliestTimestamp consensus.Timestamp
+const (
+	// hashRateWindow is how far back Info() looks when averaging HashRate.
+	hashRateWindow = 10 * time.Second
 
-	threads              int // how many threads the miner usually uses.
-	desiredThreads       int // 0 if not mining.
-	runningThreads       int
-	iterationsPerAttempt uint64
+	// hashSampleRetention is how long a hash sample is kept around before
+	// being pruned; it's larger than hashRateWindow so a hashRate
+	// calculation always has at least one sample older than the window to
+	// interpolate from.
+	hashSampleRetention = 2 * hashRateWindow
+)
 
-	blockChan chan consensus.Block
-	sync.RWMutex
-}
+type (
+	// hashSample records the miner's cumulative hash count at a point in
+	// time, so hashRate can derive a rate from the samples that fall inside
+	// hashRateWindow.
+	hashSample struct {
+		time   time.Time
+		hashes uint64
+	}
+
+	// minerInfo is the JSON structure returned by Info().
+	minerInfo struct {
+		Threads        int                   `json:"threads"`
+		RunningThreads int                   `json:"runningthreads"`
+		Address        consensus.CoinAddress `json:"address"`
+		Target         consensus.Target      `json:"target"`
+		HashRate       float64               `json:"hashrate"`
+		BlocksFound    uint64                `json:"blocksfound"`
+		TotalHashes    uint64                `json:"totalhashes"`
+	}
+
+	Miner struct {
+		// Block variables - helps the miner construct the next block.
+		parent            consensus.BlockID
+		transactions      []consensus.Transaction
+		address           consensus.CoinAddress
+		target            consensus.Target
+		earliestTimestamp consensus.Timestamp
+
+		threads              int // how many threads the miner usually uses.
+		desiredThreads       int // 0 if not mining.
+		runningThreads       int
+		iterationsPerAttempt uint64
+
+		blocksFound uint64
+		totalHashes uint64
+		hashSamples []hashSample
+
+		blockChan chan consensus.Block
+
+		// ctx is cancelled every time the set of hashing goroutines needs to
+		// be torn down: by Update (new block to mine on) and StartMining,
+		// which replace it with a fresh one for the new goroutines, and by
+		// StopMining and Close, which leave it cancelled. Deriving each hashing
+		// goroutine's lifetime from ctx lets all three call sites stop the
+		// goroutines without racing on the mutex.
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		sync.RWMutex
+	}
+)
 
 // New takes a block channel down which it drops blocks that it mines. It also
 // takes a thread count, which it uses to spin up miners on separate threads.
 func New(blockChan chan consensus.Block, threads int) (m *Miner) {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Miner{
 		threads:              threads,
 		iterationsPerAttempt: 256 * 1024,
 		blockChan:            blockChan,
+		ctx:                  ctx,
+		cancel:               cancel,
 	}
 }
 
 // Info() returns a JSON struct which can be parsed by frontends for displaying
 // information to the user.
 func (m *Miner) Info() ([]byte, error) {
-	return nil, nil
+	m.RLock()
+	defer m.RUnlock()
+
+	return json.Marshal(minerInfo{
+		Threads:        m.threads,
+		RunningThreads: m.runningThreads,
+		Address:        m.address,
+		Target:         m.target,
+		HashRate:       m.hashRateLocked(),
+		BlocksFound:    m.blocksFound,
+		TotalHashes:    m.totalHashes,
+	})
+}
+
+// hashRateLocked derives hashes/second from hashSamples. Callers must hold at
+// least a read lock.
+func (m *Miner) hashRateLocked() float64 {
+	if len(m.hashSamples) < 2 {
+		return 0
+	}
+
+	cutoff := time.Now().Add(-hashRateWindow)
+	first := m.hashSamples[0]
+	for _, s := range m.hashSamples {
+		if s.time.After(cutoff) {
+			break
+		}
+		first = s
+	}
+	last := m.hashSamples[len(m.hashSamples)-1]
+
+	elapsed := last.time.Sub(first.time).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(last.hashes-first.hashes) / elapsed
+}
+
+// recordHashes adds n to the miner's hash counters and appends a sample for
+// hashRateLocked, pruning samples that have aged out of hashSampleRetention.
+func (m *Miner) recordHashes(n uint64) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.totalHashes += n
+	now := time.Now()
+	m.hashSamples = append(m.hashSamples, hashSample{time: now, hashes: m.totalHashes})
+
+	cutoff := now.Add(-hashSampleRetention)
+	i := 0
+	for i < len(m.hashSamples) && m.hashSamples[i].time.Before(cutoff) {
+		i++
+	}
+	m.hashSamples = m.hashSamples[i:]
 }
 
 // SubsidyAddress returns the address that is currently being used by the miner
@@ -49,7 +156,8 @@ func (m *Miner) SubsidyAddress() consensus.CoinAddress {
 }
 
 // Update changes what block the miner is mining on. Changes include address
-// and target.
+// and target. If the miner is currently mining, its hashing goroutines are
+// cancelled and respawned so they pick up the new block immediately.
 func (m *Miner) Update(parent consensus.BlockID, transactions []consensus.Transaction, target consensus.Target, address consensus.CoinAddress, earliestTimestamp consensus.Timestamp) error {
 	m.Lock()
 	defer m.Unlock()
@@ -59,5 +167,160 @@ func (m *Miner) Update(parent consensus.BlockID, transactions []consensus.Transa
 	m.target = target
 	m.address = address
 	m.earliestTimestamp = earliestTimestamp
+
+	if m.desiredThreads > 0 {
+		m.restartLocked()
+	}
+	return nil
+}
+
+// StartMining spins up threads hashing goroutines against the miner's
+// current block variables. Calling StartMining while already mining
+// restarts the goroutines so they pick up the new thread count.
+func (m *Miner) StartMining(threads int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.threads = threads
+	m.desiredThreads = threads
+	m.restartLocked()
+}
+
+// StopMining cancels all hashing goroutines and marks the miner as not
+// mining.
+func (m *Miner) StopMining() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.desiredThreads = 0
+	m.cancel()
+	m.runningThreads = 0
+}
+
+// Close cancels all hashing goroutines and releases the miner's resources.
+func (m *Miner) Close() error {
+	m.StopMining()
 	return nil
 }
+
+// restartLocked cancels any hashing goroutines spawned by a previous call to
+// StartMining/Update, then spawns desiredThreads new ones against a fresh
+// context. The caller must hold the lock.
+func (m *Miner) restartLocked() {
+	m.cancel()
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.runningThreads = m.desiredThreads
+
+	for i := 0; i < m.desiredThreads; i++ {
+		go m.threadedMine(m.ctx, i, m.desiredThreads)
+	}
+}
+
+// threadedMine repeatedly calls SolveBlock using the miner's current block
+// variables until ctx is cancelled (by Update, StopMining, or Close) or it
+// finds a block, in which case it reports the block and returns; a new
+// goroutine is spawned by the triggering call to pick up where it left off.
+//
+// threadIndex and numThreads partition the nonce space across the threads
+// started by a single restartLocked call: thread threadIndex only ever
+// tries nonces congruent to threadIndex modulo numThreads, so concurrent
+// threads search disjoint ranges instead of racing over the same one. Each
+// batch also starts from a freshly randomized point within that thread's
+// range, so repeated batches against an unchanged block (e.g. because the
+// wall-clock second hasn't ticked over) don't redo the same search.
+func (m *Miner) threadedMine(ctx context.Context, threadIndex, numThreads int) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano() ^ int64(threadIndex)))
+	stride := uint64(numThreads)
+
+	defer func() {
+		m.Lock()
+		// A goroutine from a generation that restartLocked has already
+		// replaced isn't part of runningThreads any more.
+		if ctx == m.ctx && m.runningThreads > 0 {
+			m.runningThreads--
+		}
+		m.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		m.RLock()
+		parent := m.parent
+		transactions := m.transactions
+		target := m.target
+		address := m.address
+		earliestTimestamp := m.earliestTimestamp
+		iterations := m.iterationsPerAttempt
+		m.RUnlock()
+
+		startNonce := (rng.Uint64()/stride)*stride + uint64(threadIndex)
+		b, solved := m.SolveBlock(parent, transactions, target, address, earliestTimestamp, iterations, startNonce, stride)
+		m.recordHashes(iterations)
+		if !solved {
+			continue
+		}
+
+		m.Lock()
+		m.blocksFound++
+		m.Unlock()
+
+		select {
+		case m.blockChan <- b:
+		case <-ctx.Done():
+		}
+		return
+	}
+}
+
+// SolveBlock tries up to iterations nonces, starting at startNonce and
+// stepping by stride each time, for the block described by parent,
+// transactions, address, and earliestTimestamp. Passing a distinct
+// (startNonce mod stride) per caller, with a shared stride, partitions the
+// nonce space so concurrent callers explore disjoint ranges. It returns the
+// solved block and true as soon as one satisfies target, or a zero-value
+// block and false if none of the tried nonces do.
+func (m *Miner) SolveBlock(parent consensus.BlockID, transactions []consensus.Transaction, target consensus.Target, address consensus.CoinAddress, earliestTimestamp consensus.Timestamp, iterations, startNonce, stride uint64) (b consensus.Block, solved bool) {
+	b = consensus.Block{
+		ParentID:     parent,
+		Timestamp:    blockTimestamp(earliestTimestamp),
+		MinerAddress: address,
+		Transactions: transactions,
+	}
+	setNonce(&b, startNonce)
+
+	for i := uint64(0); i < iterations; i++ {
+		if b.CheckTarget(target) {
+			return b, true
+		}
+		addNonce(&b, stride)
+	}
+	return consensus.Block{}, false
+}
+
+// setNonce and addNonce read and write a block's Nonce through its declared
+// [8]byte encoding instead of incrementing it one step at a time, so
+// SolveBlock can jump straight to an arbitrary starting point and advance by
+// an arbitrary stride.
+func setNonce(b *consensus.Block, n uint64) {
+	binary.LittleEndian.PutUint64(b.Nonce[:], n)
+}
+
+func addNonce(b *consensus.Block, delta uint64) {
+	n := binary.LittleEndian.Uint64(b.Nonce[:]) + delta
+	binary.LittleEndian.PutUint64(b.Nonce[:], n)
+}
+
+// blockTimestamp returns the current time as a consensus.Timestamp, clamped
+// to never precede earliestTimestamp.
+func blockTimestamp(earliestTimestamp consensus.Timestamp) consensus.Timestamp {
+	now := consensus.Timestamp(time.Now().Unix())
+	if now < earliestTimestamp {
+		return earliestTimestamp
+	}
+	return now
+}
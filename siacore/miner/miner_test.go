@@ -0,0 +1,197 @@
+package miner
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/consensus"
+)
+
+// waitForRunningThreads polls m.runningThreads until it equals want or
+// timeout elapses, returning the last observed value.
+func waitForRunningThreads(m *Miner, want int, timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		m.RLock()
+		running := m.runningThreads
+		m.RUnlock()
+		if running == want || time.Now().After(deadline) {
+			return running
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestStartStopMining checks that StartMining spins up the requested number
+// of threads and that StopMining brings runningThreads back down to zero.
+func TestStartStopMining(t *testing.T) {
+	m := New(make(chan consensus.Block), 0)
+
+	m.StartMining(4)
+	if running := waitForRunningThreads(m, 4, time.Second); running != 4 {
+		t.Fatalf("expected 4 running threads, got %d", running)
+	}
+
+	m.StopMining()
+	if running := waitForRunningThreads(m, 0, time.Second); running != 0 {
+		t.Fatalf("expected 0 running threads after StopMining, got %d", running)
+	}
+}
+
+// TestUpdateRestartsThreads checks that calling Update while mining cancels
+// the old hashing goroutines and starts a fresh set, rather than leaving the
+// old ones running alongside the new ones.
+func TestUpdateRestartsThreads(t *testing.T) {
+	m := New(make(chan consensus.Block), 0)
+	defer m.Close()
+
+	m.StartMining(3)
+	if running := waitForRunningThreads(m, 3, time.Second); running != 3 {
+		t.Fatalf("expected 3 running threads, got %d", running)
+	}
+
+	err := m.Update(consensus.BlockID{}, nil, consensus.Target{}, consensus.CoinAddress{}, consensus.Timestamp(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if running := waitForRunningThreads(m, 3, time.Second); running != 3 {
+		t.Fatalf("expected 3 running threads after Update, got %d", running)
+	}
+}
+
+// TestClose checks that Close cancels any hashing goroutines.
+func TestClose(t *testing.T) {
+	m := New(make(chan consensus.Block), 0)
+	m.StartMining(2)
+	if running := waitForRunningThreads(m, 2, time.Second); running != 2 {
+		t.Fatalf("expected 2 running threads, got %d", running)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if running := waitForRunningThreads(m, 0, time.Second); running != 0 {
+		t.Fatalf("expected 0 running threads after Close, got %d", running)
+	}
+}
+
+// TestHashRateLocked checks that hashRateLocked derives a sane rate from a
+// handful of samples.
+func TestHashRateLocked(t *testing.T) {
+	m := &Miner{}
+	now := time.Now()
+	m.hashSamples = []hashSample{
+		{time: now.Add(-2 * time.Second), hashes: 0},
+		{time: now, hashes: 2048},
+	}
+
+	rate := m.hashRateLocked()
+	if rate <= 0 {
+		t.Fatalf("expected a positive hash rate, got %v", rate)
+	}
+}
+
+// TestHashRateLockedNoSamples checks that hashRateLocked doesn't panic or
+// divide by zero when fewer than two samples are available.
+func TestHashRateLockedNoSamples(t *testing.T) {
+	m := &Miner{}
+	if rate := m.hashRateLocked(); rate != 0 {
+		t.Fatalf("expected 0 with no samples, got %v", rate)
+	}
+
+	m.hashSamples = []hashSample{{time: time.Now(), hashes: 100}}
+	if rate := m.hashRateLocked(); rate != 0 {
+		t.Fatalf("expected 0 with one sample, got %v", rate)
+	}
+}
+
+// easyTarget returns a consensus.Target that any block satisfies, so tests
+// can drive SolveBlock/StartMining to an actual solve instead of only
+// exercising their bookkeeping.
+func easyTarget() (target consensus.Target) {
+	for i := range target {
+		target[i] = 0xff
+	}
+	return target
+}
+
+// TestSolveBlockFindsValidBlock checks that SolveBlock returns a block that
+// actually satisfies target, not just that it loops iterations times.
+func TestSolveBlockFindsValidBlock(t *testing.T) {
+	m := New(make(chan consensus.Block), 0)
+	target := easyTarget()
+
+	b, solved := m.SolveBlock(consensus.BlockID{}, nil, target, consensus.CoinAddress{}, consensus.Timestamp(0), 1024, 0, 1)
+	if !solved {
+		t.Fatal("expected SolveBlock to solve a block against an easy target")
+	}
+	if !b.CheckTarget(target) {
+		t.Fatal("SolveBlock returned a block that doesn't satisfy target")
+	}
+}
+
+// TestSolveBlockSetsMinerAddress checks that SolveBlock pays the subsidy to
+// the address it was given instead of leaving it zero-valued.
+func TestSolveBlockSetsMinerAddress(t *testing.T) {
+	m := New(make(chan consensus.Block), 0)
+	target := easyTarget()
+
+	var address consensus.CoinAddress
+	address[0] = 0xab
+
+	b, solved := m.SolveBlock(consensus.BlockID{}, nil, target, address, consensus.Timestamp(0), 1024, 0, 1)
+	if !solved {
+		t.Fatal("expected SolveBlock to solve a block against an easy target")
+	}
+	if b.MinerAddress != address {
+		t.Fatalf("expected MinerAddress %v, got %v", address, b.MinerAddress)
+	}
+}
+
+// TestStartMiningFindsBlock runs StartMining end-to-end against an easy
+// target and checks that a valid block comes out on blockChan.
+func TestStartMiningFindsBlock(t *testing.T) {
+	blockChan := make(chan consensus.Block, 1)
+	m := New(blockChan, 0)
+	defer m.Close()
+
+	target := easyTarget()
+	err := m.Update(consensus.BlockID{}, nil, target, consensus.CoinAddress{}, consensus.Timestamp(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.StartMining(1)
+
+	select {
+	case b := <-blockChan:
+		if !b.CheckTarget(target) {
+			t.Fatal("mined block doesn't satisfy target")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartMining never produced a block against an easy target")
+	}
+}
+
+// TestInfo checks that Info reports the miner's running thread count as
+// valid JSON.
+func TestInfo(t *testing.T) {
+	m := New(make(chan consensus.Block), 0)
+	m.StartMining(2)
+	defer m.StopMining()
+	waitForRunningThreads(m, 2, time.Second)
+
+	b, err := m.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var info minerInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.RunningThreads != 2 {
+		t.Fatalf("expected 2 running threads in Info, got %d", info.RunningThreads)
+	}
+}
@@ -1,8 +1,10 @@
 package dependencies
 
 import (
+	"encoding/json"
 	"net"
 	"sync"
+	"time"
 
 	"gitlab.com/NebulousLabs/Sia/modules"
 )
@@ -258,3 +260,137 @@ func (d *dependencyCustomResolver) Disrupt(s string) bool {
 func (d *dependencyCustomResolver) Resolver() modules.Resolver {
 	return customResolver{d.lookupIP}
 }
+
+type (
+	// Step describes one stage of a DependencyScenario's script. A step
+	// applies to calls to Disrupt with the given Keyword: the first Skip of
+	// those calls are ignored, and the next Repeat of them fire (return
+	// true). The call that fires the last of them moves the scenario on to
+	// the next step and then sleeps for ThenSleep before returning. A
+	// Repeat of 0 means the step fires forever and must be the
+	// last step in the scenario.
+	Step struct {
+		Keyword   string
+		Skip      int
+		Repeat    int
+		ThenSleep time.Duration
+	}
+
+	// DependencyScenario plays back an ordered list of Steps from Disrupt,
+	// making it possible to script multi-stage reproducers (e.g. "fail the
+	// 2nd upload revision, then on recovery fail the 1st contract save, then
+	// succeed") that DependencyInterruptOnceOnKeyword and
+	// DependencyInterruptAfterNCalls can't express on their own.
+	DependencyScenario struct {
+		modules.ProductionDependencies
+		mu      sync.Mutex
+		steps   []Step
+		current int // index into steps of the step being played
+		skipped int // calls skipped so far for the current step
+		fired   int // calls fired so far for the current step
+	}
+
+	// Recorder wraps ProductionDependencies and logs every keyword passed to
+	// Disrupt during a real run, without altering that run's behavior. The
+	// log can later be turned into a DependencyScenario to replay the run
+	// deterministically.
+	Recorder struct {
+		modules.ProductionDependencies
+		mu  sync.Mutex
+		log []string
+	}
+)
+
+// NewDependencyScenario creates a DependencyScenario that plays back steps in
+// order.
+func NewDependencyScenario(steps []Step) *DependencyScenario {
+	return &DependencyScenario{
+		steps: steps,
+	}
+}
+
+// NewDependencyScenarioFromJSON creates a DependencyScenario from a JSON
+// encoded list of Steps, so integration tests can load reproducers from
+// disk.
+func NewDependencyScenarioFromJSON(b []byte) (*DependencyScenario, error) {
+	var steps []Step
+	if err := json.Unmarshal(b, &steps); err != nil {
+		return nil, err
+	}
+	return NewDependencyScenario(steps), nil
+}
+
+// Disrupt advances the scenario's counters for the step it's currently on
+// and returns true only if s matches that step's Keyword and the step's
+// Skip calls have already been consumed. Once a step with a nonzero Repeat
+// has fired Repeat times, the scenario moves on to the next step and sleeps
+// for ThenSleep; the sleep happens after the mutex is released, so it
+// doesn't stall concurrent Disrupt calls for unrelated keywords.
+func (d *DependencyScenario) Disrupt(s string) bool {
+	d.mu.Lock()
+
+	if d.current >= len(d.steps) {
+		d.mu.Unlock()
+		return false
+	}
+	step := d.steps[d.current]
+	if step.Keyword != s {
+		d.mu.Unlock()
+		return false
+	}
+	if d.skipped < step.Skip {
+		d.skipped++
+		d.mu.Unlock()
+		return false
+	}
+
+	d.fired++
+	advanced := step.Repeat != 0 && d.fired >= step.Repeat
+	if advanced {
+		d.current++
+		d.skipped = 0
+		d.fired = 0
+	}
+	d.mu.Unlock()
+
+	if advanced && step.ThenSleep > 0 {
+		time.Sleep(step.ThenSleep)
+	}
+	return true
+}
+
+// Disrupt records s and always returns false, since a Recorder is meant to
+// observe a real run rather than alter its behavior.
+func (d *Recorder) Disrupt(s string) bool {
+	d.mu.Lock()
+	d.log = append(d.log, s)
+	d.mu.Unlock()
+	return false
+}
+
+// Scenario converts the keywords recorded so far into a DependencyScenario
+// that fires on each of them exactly once, in the order they were observed,
+// reproducing the run deterministically.
+func (d *Recorder) Scenario() *DependencyScenario {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	steps := make([]Step, len(d.log))
+	for i, keyword := range d.log {
+		steps[i] = Step{Keyword: keyword, Repeat: 1}
+	}
+	return NewDependencyScenario(steps)
+}
+
+// MarshalJSON writes the recorded log out as a scenario file that can later
+// be loaded with NewDependencyScenarioFromJSON for deterministic replay.
+func (d *Recorder) MarshalJSON() ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	steps := make([]Step, len(d.log))
+	for i, keyword := range d.log {
+		steps[i] = Step{Keyword: keyword, Repeat: 1}
+	}
+	return json.Marshal(steps)
+}
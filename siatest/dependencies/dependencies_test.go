@@ -0,0 +1,127 @@
+package dependencies
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// TestDependencyScenarioMultiStep checks that a DependencyScenario advances
+// through steps with different keywords in order, honoring each step's Skip
+// and Repeat counts and ignoring calls that don't match the step it's
+// currently on.
+func TestDependencyScenarioMultiStep(t *testing.T) {
+	d := NewDependencyScenario([]Step{
+		{Keyword: "a", Skip: 1, Repeat: 2},
+		{Keyword: "b", Repeat: 1},
+	})
+
+	// "b" shouldn't fire while the scenario is still on the "a" step.
+	if d.Disrupt("b") {
+		t.Fatal("expected Disrupt(\"b\") to be a no-op during the \"a\" step")
+	}
+
+	// The first "a" call is skipped.
+	if d.Disrupt("a") {
+		t.Fatal("expected the first \"a\" call to be skipped")
+	}
+	// The next two fire; the second of them advances to the "b" step.
+	if !d.Disrupt("a") {
+		t.Fatal("expected the second \"a\" call to fire")
+	}
+	if !d.Disrupt("a") {
+		t.Fatal("expected the third \"a\" call to fire")
+	}
+
+	// The scenario has moved on, so further "a" calls are no-ops.
+	if d.Disrupt("a") {
+		t.Fatal("expected Disrupt(\"a\") to be a no-op after the \"a\" step finished")
+	}
+	if !d.Disrupt("b") {
+		t.Fatal("expected the \"b\" call to fire")
+	}
+	if d.Disrupt("b") {
+		t.Fatal("expected Disrupt to be a no-op once every step has fired")
+	}
+}
+
+// TestNewDependencyScenarioFromJSON checks that a DependencyScenario loaded
+// from JSON behaves the same as one built directly from a []Step.
+func TestNewDependencyScenarioFromJSON(t *testing.T) {
+	steps := []Step{
+		{Keyword: "x", Repeat: 1},
+		{Keyword: "y", Repeat: 1},
+	}
+	b, err := json.Marshal(steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDependencyScenarioFromJSON(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d.steps, steps) {
+		t.Fatalf("expected steps %+v, got %+v", steps, d.steps)
+	}
+
+	if !d.Disrupt("x") {
+		t.Fatal("expected the \"x\" step to fire")
+	}
+	if !d.Disrupt("y") {
+		t.Fatal("expected the \"y\" step to fire")
+	}
+}
+
+// TestNewDependencyScenarioFromJSONInvalid checks that malformed JSON is
+// reported as an error instead of producing a zero-value scenario.
+func TestNewDependencyScenarioFromJSONInvalid(t *testing.T) {
+	if _, err := NewDependencyScenarioFromJSON([]byte("not json")); err == nil {
+		t.Fatal("expected an error for malformed JSON")
+	}
+}
+
+// TestRecorderScenario checks that replaying a Recorder's log through
+// Scenario reproduces the same sequence of Disrupt calls.
+func TestRecorderScenario(t *testing.T) {
+	r := &Recorder{}
+	keywords := []string{"first", "second", "second", "third"}
+	for _, k := range keywords {
+		if r.Disrupt(k) {
+			t.Fatal("expected Recorder.Disrupt to always return false")
+		}
+	}
+
+	d := r.Scenario()
+	for i, k := range keywords {
+		if !d.Disrupt(k) {
+			t.Fatalf("replayed step %d (%q) didn't fire", i, k)
+		}
+	}
+}
+
+// TestRecorderMarshalJSON checks that a Recorder's MarshalJSON output loads
+// back with NewDependencyScenarioFromJSON and reproduces the same recorded
+// run.
+func TestRecorderMarshalJSON(t *testing.T) {
+	r := &Recorder{}
+	keywords := []string{"alpha", "beta"}
+	for _, k := range keywords {
+		r.Disrupt(k)
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDependencyScenarioFromJSON(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, k := range keywords {
+		if !d.Disrupt(k) {
+			t.Fatalf("replayed step %d (%q) didn't fire", i, k)
+		}
+	}
+}